@@ -15,6 +15,7 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/binary"
+	"fmt"
 	"io"
 
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog/colinfo"
@@ -24,26 +25,93 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/sql/opt/constraint"
 	"github.com/cockroachdb/cockroach/pkg/sql/opt/exec"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
 	"github.com/cockroachdb/cockroach/pkg/util"
+	"github.com/cockroachdb/cockroach/pkg/util/encoding"
 	"github.com/cockroachdb/cockroach/pkg/util/errorutil"
 	"github.com/cockroachdb/errors"
+	"github.com/lib/pq/oid"
 )
 
 func init() {
-	if numOperators != 57 {
-		// If this error occurs please make sure the new op is the last one in order
-		// to not invalidate existing plan gists/hashes. If we are just adding an
-		// operator at the end there's no need to update version below and we can
-		// just bump the hardcoded literal here.
-		panic(errors.AssertionFailedf("Operator field changed (%d), please update check and consider incrementing version", numOperators))
+	m, ok := gistVersionManifests[version]
+	if !ok {
+		panic(errors.AssertionFailedf("no manifest registered for current plan gist version %d", version))
+	}
+	if m.numOperators != numOperators {
+		// If this error occurs please make sure the new op is the last one in
+		// order to not invalidate existing plan gists/hashes, then update
+		// numOperators in the manifest for `version` below. If we are removing
+		// an operator, reordering operators, or otherwise changing what an
+		// existing operator byte means, increment version instead and add a
+		// migrate func to the old version's manifest that maps its operator
+		// bytes onto the current operator set.
+		panic(errors.AssertionFailedf(
+			"Operator field changed (%d), please update the manifest for version %d", numOperators, version))
 	}
 }
 
 // version tracks major changes to how we encode plans or to the operator set.
 // It isn't necessary to increment it when adding a single operator but if we
 // remove an operator or change the operator set or decide to use a more
-// efficient encoding version should be incremented.
-var version = 1
+// efficient encoding version should be incremented. See gistVersionManifests.
+//
+// v2 started encoding the shape that column orderings, node column
+// ordinals, result columns, and rows contribute to a plan (v1 encoded only
+// their lengths, if that); decodeColumnOrdering, decodeNodeColumnOrdinals,
+// decodeResultColumns, and decodeRows all check f.gistVersion so they can
+// still read v1 gists, which have nothing (or only a length) following the
+// point where these fields appear.
+var version = 2
+
+// gistOperatorMigration adapts an operator byte that was assigned under an
+// older wire version to the execOperator value understood by the decoder
+// registered for `version`. Implementations may also consume (or synthesize)
+// any bytes that moved, were dropped, or need zero-value padding as a result
+// of the operator set changing between versions; they run before the
+// target decodeOperatorBody so that function never has to know about older
+// layouts.
+type gistOperatorMigration func(f *PlanGistFactory, opByte byte) (execOperator, error)
+
+// gistVersionManifest describes everything the decoder needs in order to
+// interpret a gist encoded under a particular wire version.
+type gistVersionManifest struct {
+	// numOperators is the number of operators known at this version. It is
+	// only meaningful (and only checked at init time) for the current
+	// version; it guards against silently shipping an operator set change
+	// without bumping version or updating this manifest.
+	numOperators int
+	// decodeOperatorBody decodes the body of op, which has already been
+	// translated (via migrate, for older versions) into a value understood
+	// by the current operator set.
+	decodeOperatorBody func(f *PlanGistFactory, op execOperator) (*Node, error)
+	// migrate translates an operator byte read from a gist encoded at this
+	// manifest's version into the execOperator the current decodeOperatorBody
+	// expects. It is nil for the current version, where no translation is
+	// needed.
+	migrate gistOperatorMigration
+}
+
+// gistVersionManifests maps every plan gist wire version this binary can
+// still decode to its manifest. Entries other than `version` exist purely to
+// let us keep reading gists written by older binaries (e.g. from telemetry,
+// statement diagnostics, or logs) across operator set changes; they route
+// through migrate and then fall through to the current version's
+// decodeOperatorBody.
+var gistVersionManifests = map[int]gistVersionManifest{
+	1: {
+		numOperators:       57,
+		decodeOperatorBody: (*PlanGistFactory).decodeOperatorBody,
+	},
+	2: {
+		// v2 didn't change the operator set, only the shape of a handful of
+		// fields within some operators, so it reuses v1's operator bytes and
+		// decodeOperatorBody as-is; the field-level version checks live in
+		// the individual decode methods below.
+		numOperators:       57,
+		decodeOperatorBody: (*PlanGistFactory).decodeOperatorBody,
+	},
+}
 
 // PlanGist is a compact representation of a logical plan meant to be used as
 // a key and log for different plans used to implement a particular query. A
@@ -95,6 +163,11 @@ type PlanGistFactory struct {
 
 	nodeStack []*Node
 	catalog   cat.Catalog
+
+	// gistVersion is the wire version read from the header of the gist
+	// currently being decoded. It is only set (and only differs from
+	// `version`) on the decode path.
+	gistVersion int
 }
 
 var _ exec.Factory = &PlanGistFactory{}
@@ -186,9 +259,10 @@ func DecodePlanGistToPlan(s string, cat cat.Catalog) (plan *Plan, retErr error)
 	}()
 
 	ver := f.decodeInt()
-	if ver != version {
+	if _, ok := gistVersionManifests[ver]; !ok {
 		return nil, errors.Errorf("unsupported old plan gist version %d", ver)
 	}
+	f.gistVersion = ver
 
 	for {
 		op := f.decodeOp()
@@ -213,12 +287,34 @@ func DecodePlanGistToPlan(s string, cat cat.Catalog) (plan *Plan, retErr error)
 	return plan, nil
 }
 
+// decodeOp reads the next operator byte and dispatches it through the
+// manifest registered for the gist's wire version, migrating the byte
+// forward to the current operator set first if the gist was written by an
+// older binary.
 func (f *PlanGistFactory) decodeOp() execOperator {
 	val, err := f.buffer.ReadByte()
 	if err != nil || val == 0 {
 		return unknownOp
 	}
-	n, err := f.decodeOperatorBody(execOperator(val))
+
+	srcManifest, ok := gistVersionManifests[f.gistVersion]
+	if !ok {
+		panic(errors.AssertionFailedf("no manifest registered for plan gist version %d", f.gistVersion))
+	}
+
+	op := execOperator(val)
+	decode := srcManifest.decodeOperatorBody
+	if f.gistVersion != version {
+		if srcManifest.migrate != nil {
+			op, err = srcManifest.migrate(f, val)
+			if err != nil {
+				panic(err)
+			}
+		}
+		decode = gistVersionManifests[version].decodeOperatorBody
+	}
+
+	n, err := decode(f, op)
 	if err != nil {
 		panic(err)
 	}
@@ -312,23 +408,87 @@ func (f *PlanGistFactory) decodeSchema() cat.Schema {
 	return nil
 }
 
+// encodeNodeColumnOrdinals writes the ordinals as a varint-delta list: each
+// entry is encoded relative to the previous one, which keeps the common case
+// (a contiguous or near-contiguous run of columns) compact.
 func (f *PlanGistFactory) encodeNodeColumnOrdinals(vals []exec.NodeColumnOrdinal) {
 	f.encodeInt(len(vals))
+	prev := 0
+	for _, v := range vals {
+		f.encodeInt(int(v) - prev)
+		prev = int(v)
+	}
 }
 
 func (f *PlanGistFactory) decodeNodeColumnOrdinals() []exec.NodeColumnOrdinal {
 	l := f.decodeInt()
 	vals := make([]exec.NodeColumnOrdinal, l)
+	if f.gistVersion < 2 {
+		// v1 only encoded the length.
+		return vals
+	}
+	prev := 0
+	for i := range vals {
+		prev += f.decodeInt()
+		vals[i] = exec.NodeColumnOrdinal(prev)
+	}
 	return vals
 }
 
+// encodeResultColumns writes each column's type oid to the buffer so that
+// decode can recover real types. Names are written neither to the buffer nor
+// to the hash, so neither the gist nor its hash changes with column aliases,
+// per the PlanGist doc comment.
 func (f *PlanGistFactory) encodeResultColumns(vals colinfo.ResultColumns) {
 	f.encodeInt(len(vals))
+	for _, c := range vals {
+		var o oid.Oid
+		if c.Typ != nil {
+			o = c.Typ.Oid()
+		}
+		f.encodeInt(int(o))
+	}
 }
 
 func (f *PlanGistFactory) decodeResultColumns() colinfo.ResultColumns {
 	numCols := f.decodeInt()
-	return make(colinfo.ResultColumns, numCols)
+	cols := make(colinfo.ResultColumns, numCols)
+	if f.gistVersion < 2 {
+		// v1 only encoded the length.
+		return cols
+	}
+	for i := range cols {
+		o := f.decodeInt()
+		// The real column name isn't in the gist (see encodeResultColumns);
+		// fall back to a positional placeholder.
+		cols[i].Name = fmt.Sprintf("column%d", i+1)
+		cols[i].Typ = types.Unknown
+		if typ := f.decodeTypeOid(oid.Oid(o)); typ != nil {
+			cols[i].Typ = typ
+		}
+	}
+	return cols
+}
+
+// decodeTypeOid resolves a type oid written by encodeResultColumns/encodeRows
+// back to a *types.T, first checking builtin types and falling back to the
+// catalog for user-defined types. Returns nil, and lets the caller fall back
+// to an unknown/placeholder type, if neither resolves it.
+func (f *PlanGistFactory) decodeTypeOid(o oid.Oid) *types.T {
+	if o == 0 {
+		return nil
+	}
+	if typ, ok := types.OidToType[o]; ok {
+		return typ
+	}
+	if f.catalog == nil {
+		return nil
+	}
+	typ, err := f.catalog.ResolveTypeByOID(context.TODO(), o)
+	if err != nil {
+		return nil
+	}
+	return typ
 }
 
 func (f *PlanGistFactory) encodeByte(b byte) {
@@ -364,12 +524,31 @@ func (f *PlanGistFactory) decodeBool() bool {
 	return val != 0
 }
 
-// TODO: enable this or remove it...
+// encodeColumnOrdering writes each column's ordinal and sort direction as a
+// varint/byte pair.
 func (f *PlanGistFactory) encodeColumnOrdering(cols colinfo.ColumnOrdering) {
+	f.encodeInt(len(cols))
+	for _, col := range cols {
+		f.encodeInt(col.ColIdx)
+		f.encodeByte(byte(col.Direction))
+	}
 }
 
 func (f *PlanGistFactory) decodeColumnOrdering() colinfo.ColumnOrdering {
-	return nil
+	if f.gistVersion < 2 {
+		// v1 wrote nothing at all for orderings.
+		return nil
+	}
+	l := f.decodeInt()
+	if l == 0 {
+		return nil
+	}
+	cols := make(colinfo.ColumnOrdering, l)
+	for i := range cols {
+		cols[i].ColIdx = f.decodeInt()
+		cols[i].Direction = encoding.Direction(f.decodeByte())
+	}
+	return cols
 }
 
 func (f *PlanGistFactory) encodeScanParams(params exec.ScanParams) {
@@ -420,11 +599,41 @@ func (f *PlanGistFactory) decodeScanParams() exec.ScanParams {
 	return exec.ScanParams{NeededCols: neededCols, IndexConstraint: idxConstraint, InvertedConstraint: invertedConstraint, HardLimit: int64(hardLimit)}
 }
 
+// encodeRows writes the shape of a literal values row set: a column count per
+// row plus a type oid per cell. Literal values themselves are never encoded,
+// per the PlanGist doc comment above.
 func (f *PlanGistFactory) encodeRows(rows [][]tree.TypedExpr) {
 	f.encodeInt(len(rows))
+	for _, row := range rows {
+		f.encodeInt(len(row))
+		for _, cell := range row {
+			var o oid.Oid
+			if cell != nil && cell.ResolvedType() != nil {
+				o = cell.ResolvedType().Oid()
+			}
+			f.encodeInt(int(o))
+		}
+	}
 }
 
 func (f *PlanGistFactory) decodeRows() [][]tree.TypedExpr {
 	numRows := f.decodeInt()
-	return make([][]tree.TypedExpr, numRows)
+	rows := make([][]tree.TypedExpr, numRows)
+	if f.gistVersion < 2 {
+		// v1 only encoded the row count.
+		return rows
+	}
+	for i := range rows {
+		numCols := f.decodeInt()
+		row := make([]tree.TypedExpr, numCols)
+		for j := range row {
+			// The oid only preserves the cell's shape; there's no literal
+			// value to reconstruct, so every cell decodes to an untyped
+			// placeholder regardless of whether the oid resolves.
+			f.decodeInt()
+			row[j] = tree.DNull
+		}
+		rows[i] = row
+	}
+	return rows
 }
@@ -0,0 +1,133 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package scexec
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/schemachanger/scop"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+var errTest = errors.New("boom")
+
+// fakeTableOp is shaped like a real scop.Op: a struct with a descpb.ID field
+// identifying the descriptor it targets.
+type fakeTableOp struct {
+	TableID descpb.ID
+}
+
+func (fakeTableOp) Op() {}
+
+type fakeOps struct {
+	ops []scop.Op
+	typ scop.OpType
+}
+
+func (o fakeOps) Slice() []scop.Op  { return o.ops }
+func (o fakeOps) Type() scop.OpType { return o.typ }
+
+type fakeCatalog struct {
+	descs map[descpb.ID]catalog.MutableDescriptor
+}
+
+func (c fakeCatalog) NewCatalogChangeBatcher() CatalogChangeBatcher { return nil }
+
+func (c fakeCatalog) MustReadMutableDescriptor(
+	_ context.Context, id descpb.ID,
+) (catalog.MutableDescriptor, error) {
+	desc, ok := c.descs[id]
+	if !ok {
+		return nil, errors.Newf("descriptor %d not found", id)
+	}
+	return desc, nil
+}
+
+type fakeDependencies struct {
+	catalog fakeCatalog
+}
+
+func (d fakeDependencies) TestingKnobs() *TestingKnobs { return nil }
+func (d fakeDependencies) Statements() []string        { return nil }
+func (d fakeDependencies) Phase() scop.Phase           { return scop.Phase(0) }
+func (d fakeDependencies) Catalog() Catalog            { return d.catalog }
+
+func TestPreflightDescriptorIDs(t *testing.T) {
+	op := fakeTableOp{TableID: 42}
+	var ids []descpb.ID
+	require.NotPanics(t, func() {
+		ids = preflightDescriptorIDs(op)
+	})
+	require.Equal(t, []descpb.ID{42}, ids)
+}
+
+func TestPreflightReportsMissingDescriptor(t *testing.T) {
+	deps := fakeDependencies{catalog: fakeCatalog{descs: map[descpb.ID]catalog.MutableDescriptor{}}}
+	ops := fakeOps{ops: []scop.Op{fakeTableOp{TableID: 7}}}
+
+	err := Preflight(context.Background(), deps, ops)
+	require.Error(t, err)
+
+	var preflightErr *PreflightError
+	require.ErrorAs(t, err, &preflightErr)
+	require.Len(t, preflightErr.Issues, 1)
+	require.Equal(t, PreflightCheckDescriptorExists, preflightErr.Issues[0].Rule)
+	require.EqualValues(t, 7, preflightErr.Issues[0].ID)
+}
+
+func TestSubtractPreflightRules(t *testing.T) {
+	all := []PreflightCheckRule{
+		PreflightCheckDescriptorExists,
+		PreflightCheckParentLinkage,
+		PreflightCheckConstraintIDs,
+		PreflightCheckForeignKeys,
+	}
+
+	t.Run("empty whitelist keeps every rule", func(t *testing.T) {
+		require.Equal(t, all, subtractPreflightRules(all, nil))
+	})
+
+	t.Run("whitelisting descriptor-exists removes only that rule", func(t *testing.T) {
+		got := subtractPreflightRules(all, []PreflightCheckRule{PreflightCheckDescriptorExists})
+		require.Equal(t, []PreflightCheckRule{
+			PreflightCheckParentLinkage,
+			PreflightCheckConstraintIDs,
+			PreflightCheckForeignKeys,
+		}, got)
+		require.False(t, hasPreflightRule(got, PreflightCheckDescriptorExists))
+	})
+
+	t.Run("whitelisting everything leaves no rules", func(t *testing.T) {
+		require.Empty(t, subtractPreflightRules(all, all))
+	})
+}
+
+func TestHasPreflightRule(t *testing.T) {
+	rules := []PreflightCheckRule{PreflightCheckParentLinkage, PreflightCheckForeignKeys}
+	require.True(t, hasPreflightRule(rules, PreflightCheckParentLinkage))
+	require.False(t, hasPreflightRule(rules, PreflightCheckDescriptorExists))
+}
+
+func TestPreflightErrorFormatting(t *testing.T) {
+	err := &PreflightError{
+		Issues: []PreflightIssue{
+			{Rule: PreflightCheckDescriptorExists, ID: 123, Err: errTest},
+			{Rule: PreflightCheckForeignKeys, ParentID: 1, ParentSchemaID: 2, Name: "t", ID: 456, Err: errTest},
+		},
+	}
+	require.Contains(t, err.Error(), "2 issue(s)")
+	require.Contains(t, err.Error(), string(PreflightCheckDescriptorExists))
+	require.Contains(t, err.Error(), string(PreflightCheckForeignKeys))
+}